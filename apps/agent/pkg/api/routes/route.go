@@ -7,9 +7,10 @@ import (
 
 // Route 定义了一个 HTTP 路由
 type Route struct {
-	method  string        // HTTP 方法 (GET, POST 等)
-	path    string        // 路由路径
+	method  string           // HTTP 方法 (GET, POST 等)
+	path    string           // 路由路径
 	handler http.HandlerFunc // 处理函数
+	mwCount int              // 已附加的中间件数量，供 Routes() 自省使用
 }
 
 // NewRoute 创建一个新的路由
@@ -29,9 +30,15 @@ func (r *Route) WithMiddleware(mws ...Middeware) *Route {
 	for _, mw := range mws {
 		r.handler = mw(r.handler)
 	}
+	r.mwCount += len(mws)
 	return r
 }
 
+// MiddlewareCount 返回附加到这个路由上的中间件数量
+func (r *Route) MiddlewareCount() int {
+	return r.mwCount
+}
+
 // Register 将路由注册到 HTTP mux
 func (r *Route) Register(mux *http.ServeMux) {
 	mux.HandleFunc(fmt.Sprintf("%s %s", r.method, r.path), r.handler)
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Router 在 Route 之上增加分组、路径参数提取和自省能力
+// 路径参数沿用 net/http 1.22 引入的 `{name}` 模式语法，Mount 时原样交给 ServeMux 解析
+type Router struct {
+	prefix string
+	mws    []Middeware
+	routes *[]*Route // 所有分组共享同一份底层切片，这样 Routes()/Mount() 才能看到全部分组注册的路由
+}
+
+// NewRouter 创建一个新的根 Router
+func NewRouter() *Router {
+	routes := make([]*Route, 0)
+	return &Router{routes: &routes}
+}
+
+// Group 返回一个子 Router，路径前缀和中间件都在父级的基础上追加
+// 子 Router 与父 Router 共享同一份路由注册表，调用顺序不影响最终的路由集合
+func (rt *Router) Group(prefix string, mws ...Middeware) *Router {
+	combined := make([]Middeware, 0, len(rt.mws)+len(mws))
+	combined = append(combined, rt.mws...)
+	combined = append(combined, mws...)
+	return &Router{
+		prefix: rt.prefix + prefix,
+		mws:    combined,
+		routes: rt.routes,
+	}
+}
+
+// Handle 注册一个路由：路径加上当前分组的前缀，中间件链是分组中间件后跟本次调用传入的中间件
+// 注册的 handler 总是被包一层，把 *http.Request 塞进 context，这样 Param(ctx, name) 对任何
+// 通过 Router 注册的 handler 都能取到路径参数，不只是 JSON[T] 包装出来的那些
+func (rt *Router) Handle(method, path string, handler http.HandlerFunc, mws ...Middeware) *Route {
+	route := NewRoute(method, rt.prefix+path, withRequestContext(handler))
+
+	all := make([]Middeware, 0, len(rt.mws)+len(mws))
+	all = append(all, rt.mws...)
+	all = append(all, mws...)
+	route.WithMiddleware(all...)
+
+	*rt.routes = append(*rt.routes, route)
+	return route
+}
+
+func withRequestContext(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), requestCtxKey{}, r)))
+	}
+}
+
+// Mount 把所有已注册的路由展开到一个 net/http 1.22 ServeMux 里
+// 路径模式（含 {param}）由 ServeMux 自己解析，这里只是逐条调用 Route.Register
+func (rt *Router) Mount(mux *http.ServeMux) {
+	for _, route := range *rt.routes {
+		route.Register(mux)
+	}
+}
+
+// RouteInfo 是 Routes() 返回的一条自省记录，用于生成 OpenAPI 存根和 /debug/routes 页面
+type RouteInfo struct {
+	Method          string
+	Path            string
+	MiddlewareCount int
+}
+
+// Routes 返回所有已注册路由的 (method, path, middleware chain) 三元组
+func (rt *Router) Routes() []RouteInfo {
+	infos := make([]RouteInfo, 0, len(*rt.routes))
+	for _, route := range *rt.routes {
+		infos = append(infos, RouteInfo{
+			Method:          route.Method(),
+			Path:            route.Path(),
+			MiddlewareCount: route.MiddlewareCount(),
+		})
+	}
+	return infos
+}
+
+// Param 从请求上下文里取出路径参数
+// 底层就是 net/http 1.22 的 (*http.Request).PathValue，只是把签名改成了 (ctx, name)
+// 只要路由是经 Router.Handle 注册的（JSON[T] 包装的 typed handler 也不例外），这里就总能取到值
+func Param(ctx context.Context, name string) string {
+	r, ok := ctx.Value(requestCtxKey{}).(*http.Request)
+	if !ok {
+		return ""
+	}
+	return r.PathValue(name)
+}
+
+type requestCtxKey struct{}
+
+// JSON 包装一个带类型输入的 handler：先把请求体解析为 T，再把结果编码为 JSON 响应
+// handler 的返回值只要能被 encoding/json 序列化就行，错误会被翻译成 500 响应
+func JSON[T any](handler func(ctx context.Context, input T) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input T
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Router.Handle 已经把 *http.Request 塞进了 r.Context()，这里再设一次是为了
+		// 不经 Router 直接用 JSON[T] 包装一个 http.HandlerFunc 时 Param 依然可用
+		ctx := context.WithValue(r.Context(), requestCtxKey{}, r)
+		resp, err := handler(ctx, input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode response: %s", err), http.StatusInternalServerError)
+		}
+	}
+}
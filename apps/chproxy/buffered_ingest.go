@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// walBatch 是写入 WAL 时使用的序列化形式，保留 Decode 需要的全部字段
+type walBatch struct {
+	Table  string
+	Rows   []string
+	Params map[string]string
+	Format Format
+}
+
+// pendingBatch 把一个已经持久化的 Batch 和它所在的 WAL 段绑定在一起，
+// 这样 compactor 才知道段内记录全部确认后可以删除哪个文件
+type pendingBatch struct {
+	batch       *Batch
+	segmentPath string
+}
+
+// BufferedIngest 用持久化 WAL 替换掉裸的 channel 缓冲，具备三个能力：
+//  1. TryEnqueue 在饱和时立即拒绝并返回 429，而不是阻塞生产者
+//  2. 每个被接受的 Batch 先落盘（group-commit fsync）再 ack，崩溃不丢数据
+//  3. 启动时 Replay 把未确认的段重新灌回 ClickHouse，随后由 compactor 清理已确认的段
+// ingestLogger is the subset of config.Logger's slog-style API this subsystem needs
+type ingestLogger interface {
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	Info(msg string, args ...any)
+}
+
+type BufferedIngest struct {
+	wal      *WAL
+	queue    chan pendingBatch
+	capacity int
+
+	mu      sync.Mutex
+	pending map[string]int // segment path -> 尚未确认的记录数
+
+	logger ingestLogger
+}
+
+// NewBufferedIngest 打开（或恢复）一个 WAL 目录并构造 BufferedIngest
+func NewBufferedIngest(walDir string, segmentBytes int64, capacity int, logger ingestLogger) (*BufferedIngest, error) {
+	wal, err := OpenWAL(walDir, segmentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
+	bi := &BufferedIngest{
+		wal:      wal,
+		queue:    make(chan pendingBatch, capacity),
+		capacity: capacity,
+		pending:  make(map[string]int),
+		logger:   logger,
+	}
+	return bi, nil
+}
+
+// TryEnqueue 持久化一个 Batch 并将其放入处理队列
+// 队列已满时立即返回 ErrSaturated，调用方应当以 429 + Retry-After 响应客户端
+func (b *BufferedIngest) TryEnqueue(batch *Batch) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(walBatch{Table: batch.Table, Rows: batch.Rows, Params: batch.Params, Format: batch.Format}); err != nil {
+		return fmt.Errorf("failed to encode batch for wal: %w", err)
+	}
+
+	seg, err := b.wal.Append(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to append batch to wal: %w", err)
+	}
+	if err := seg.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync wal segment: %w", err)
+	}
+
+	b.mu.Lock()
+	b.pending[seg.path]++
+	b.mu.Unlock()
+
+	select {
+	case b.queue <- pendingBatch{batch: batch, segmentPath: seg.path}:
+		return nil
+	default:
+		b.mu.Lock()
+		b.pending[seg.path]--
+		b.mu.Unlock()
+		return ErrSaturated
+	}
+}
+
+// ErrSaturated 表示处理队列已满，调用方应当把它映射为 HTTP 429
+var ErrSaturated = fmt.Errorf("buffered ingest queue is saturated")
+
+// WriteRetryAfter 把 ErrSaturated 翻译为带 Retry-After 头的 429 响应
+func WriteRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
+	http.Error(w, ErrSaturated.Error(), http.StatusTooManyRequests)
+}
+
+// Replay 读取启动前遗留下来的、尚未确认的 WAL 记录并灌回处理队列
+// 必须在 Start 之前调用一次
+func (b *BufferedIngest) Replay() (int, error) {
+	records, err := b.wal.Replay()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rec := range records {
+		var wb walBatch
+		if err := gob.NewDecoder(bytes.NewReader(rec.Payload)).Decode(&wb); err != nil {
+			b.logger.Warn("skipping corrupt wal record during replay", "segment", rec.SegmentPath, "error", err.Error())
+			continue
+		}
+
+		b.mu.Lock()
+		b.pending[rec.SegmentPath]++
+		b.mu.Unlock()
+
+		b.queue <- pendingBatch{
+			batch:       &Batch{Table: wb.Table, Rows: wb.Rows, Params: wb.Params, Format: wb.Format},
+			segmentPath: rec.SegmentPath,
+		}
+	}
+	return len(records), nil
+}
+
+// Ack 标记一个 Batch 已经成功写入 ClickHouse
+// 一旦某个段内所有记录都被确认，对应的段文件会被 compactor 异步删除
+func (b *BufferedIngest) Ack(segmentPath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[segmentPath]--
+}
+
+// Dequeue 阻塞直到拿到下一个待处理的 Batch，ctx 取消时返回 ok=false
+func (b *BufferedIngest) Dequeue(ctx context.Context) (batch *Batch, segmentPath string, ok bool) {
+	select {
+	case item, open := <-b.queue:
+		if !open {
+			return nil, "", false
+		}
+		return item.batch, item.segmentPath, true
+	case <-ctx.Done():
+		return nil, "", false
+	}
+}
+
+// Close 关闭处理队列，等待消费者 drain 完毕后调用方应停止调用 TryEnqueue
+func (b *BufferedIngest) Close() {
+	close(b.queue)
+}
+
+// StartCompactor 启动一个后台 goroutine，周期性清理所有记录都已确认的段
+// 返回的 stop 函数会阻塞直到 compactor 的当前迭代完成
+func (b *BufferedIngest) StartCompactor(ctx context.Context, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.compactOnce()
+			case <-ctx.Done():
+				b.compactOnce()
+				return
+			}
+		}
+	}()
+	return func() { <-done }
+}
+
+func (b *BufferedIngest) compactOnce() {
+	activePath := b.wal.ActivePath()
+
+	b.mu.Lock()
+	toRemove := make([]string, 0)
+	for path, count := range b.pending {
+		if count <= 0 && path != activePath {
+			toRemove = append(toRemove, path)
+		}
+	}
+	for _, path := range toRemove {
+		delete(b.pending, path)
+	}
+	b.mu.Unlock()
+
+	for _, path := range toRemove {
+		if err := b.wal.RemoveSegment(path); err != nil {
+			b.logger.Error("failed to remove compacted wal segment", "segment", path, "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker 是按单个上游端点独立维护的简单熔断器
+// 连续失败达到阈值后打开熔断（在冷却期内直接拒绝请求），冷却结束后进入半开状态放行一次探测请求
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	state            circuitState
+	consecutiveFails int
+	failureThreshold int
+	cooldown         time.Duration
+	openedAt         time.Time
+}
+
+// NewCircuitBreaker 构造一个熔断器，failureThreshold 次连续失败后打开，冷却 cooldown 后探测恢复
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{failureThreshold: failureThreshold, cooldown: cooldown}
+}
+
+// Allow 判断当前是否允许放行一次请求
+func (c *CircuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != circuitOpen {
+		return true
+	}
+	if time.Since(c.openedAt) < c.cooldown {
+		return false
+	}
+	c.state = circuitHalfOpen
+	return true
+}
+
+// RecordSuccess 重置失败计数；半开状态下的成功会直接关闭熔断器
+func (c *CircuitBreaker) RecordSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consecutiveFails = 0
+	c.state = circuitClosed
+}
+
+// RecordFailure 累加失败计数，达到阈值或半开探测失败都会（重新）打开熔断器
+func (c *CircuitBreaker) RecordFailure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consecutiveFails++
+	if c.state == circuitHalfOpen || c.consecutiveFails >= c.failureThreshold {
+		c.state = circuitOpen
+		c.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb := NewCircuitBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if !cb.Allow() {
+			t.Fatalf("Allow() = false before threshold reached, want true")
+		}
+		cb.RecordFailure()
+	}
+	if !cb.Allow() {
+		t.Fatalf("Allow() = false below failure threshold, want true")
+	}
+
+	cb.RecordFailure() // third consecutive failure trips the breaker
+	if cb.Allow() {
+		t.Fatalf("Allow() = true right after tripping, want false")
+	}
+}
+
+func TestCircuitBreakerHalfOpenAfterCooldown(t *testing.T) {
+	cb := NewCircuitBreaker(1, 10*time.Millisecond)
+
+	cb.RecordFailure()
+	if cb.Allow() {
+		t.Fatalf("Allow() = true immediately after tripping, want false")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatalf("Allow() = false after cooldown elapsed, want true (half-open probe)")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := NewCircuitBreaker(1, 10*time.Millisecond)
+
+	cb.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatalf("Allow() = false after cooldown elapsed, want true (half-open probe)")
+	}
+
+	cb.RecordFailure() // failing the half-open probe should reopen immediately
+	if cb.Allow() {
+		t.Fatalf("Allow() = true right after a failed half-open probe, want false")
+	}
+}
+
+func TestCircuitBreakerSuccessClosesAndResets(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Minute)
+
+	cb.RecordFailure()
+	cb.RecordSuccess()
+	cb.RecordFailure()
+	if !cb.Allow() {
+		t.Fatalf("Allow() = false, want true: a success in between should have reset the failure streak")
+	}
+}
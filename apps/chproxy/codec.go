@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Format 标识一种 ClickHouse 输入编码
+type Format string
+
+const (
+	FormatTSV         Format = "TSV"         // 按行分隔的原始格式，兼容旧客户端
+	FormatJSONEachRow Format = "JSONEachRow" // 每行一个 JSON 对象，常见于分析类 agent
+	FormatRowBinary   Format = "RowBinary"   // ClickHouse 原生二进制行格式
+	FormatNative      Format = "Native"      // ClickHouse 原生 protobuf 格式
+	FormatParquet     Format = "Parquet"     // 列式 Parquet 文件
+)
+
+// CodecLimits 限制单个请求允许接受的行数与字节数，按格式单独配置
+// 二进制/列式格式通常单条请求携带更多数据，默认限制也更宽松
+type CodecLimits struct {
+	MaxRows  int
+	MaxBytes int64
+}
+
+// defaultCodecLimits 是各编码格式的默认限制，可通过 Config 覆盖
+var defaultCodecLimits = map[Format]CodecLimits{
+	FormatTSV:         {MaxRows: maxBatchSize, MaxBytes: 32 << 20},
+	FormatJSONEachRow: {MaxRows: maxBatchSize, MaxBytes: 32 << 20},
+	FormatRowBinary:   {MaxRows: maxBatchSize * 4, MaxBytes: 64 << 20},
+	FormatNative:      {MaxRows: maxBatchSize * 4, MaxBytes: 64 << 20},
+	FormatParquet:     {MaxRows: maxBatchSize * 10, MaxBytes: 128 << 20},
+}
+
+// BatchSchema 描述一张表的列信息，供二进制/列式编解码器校验与解码使用
+// 按表名缓存，避免每次请求都重新向 ClickHouse 探测 DESCRIBE TABLE
+type BatchSchema struct {
+	Table   string
+	Columns []string
+}
+
+var schemaCache sync.Map // map[string]*BatchSchema
+
+// cachedSchema 返回某张表最近一次缓存的 schema，调用方需要处理未命中的情况
+func cachedSchema(table string) (*BatchSchema, bool) {
+	v, ok := schemaCache.Load(table)
+	if !ok {
+		return nil, false
+	}
+	return v.(*BatchSchema), true
+}
+
+// cacheSchema 写入或刷新某张表的 schema 缓存
+func cacheSchema(schema *BatchSchema) {
+	schemaCache.Store(schema.Table, schema)
+}
+
+// probeSchema 向 ClickHouse 发一次 `DESCRIBE TABLE` 探测列布局
+// 只在 RowBinary/Native 请求碰到缓存未命中时调用一次，结果由调用方通过 cacheSchema 缓存，
+// 避免每个请求都触发一次 DESCRIBE TABLE 往返
+func probeSchema(ctx context.Context, clickhouseURL, basicAuth, table string) (*BatchSchema, error) {
+	describeQuery := fmt.Sprintf("DESCRIBE TABLE %s FORMAT JSONEachRow", table)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, clickhouseURL+"?query="+url.QueryEscape(describeQuery), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build describe table request for %s: %w", table, err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach clickhouse to describe table %s: %w", table, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clickhouse returned %d describing table %s", resp.StatusCode, table)
+	}
+
+	var columns []string
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var row struct {
+			Name string `json:"name"`
+		}
+		if err := dec.Decode(&row); err != nil {
+			return nil, fmt.Errorf("failed to parse describe table response for %s: %w", table, err)
+		}
+		columns = append(columns, row.Name)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s has no columns or does not exist", table)
+	}
+
+	return &BatchSchema{Table: table, Columns: columns}, nil
+}
+
+// resolveSchema 返回某张表的 schema，优先读缓存，未命中时探测并写入缓存
+func resolveSchema(ctx context.Context, clickhouseURL, basicAuth, table string) (*BatchSchema, error) {
+	if schema, ok := cachedSchema(table); ok {
+		return schema, nil
+	}
+
+	schema, err := probeSchema(ctx, clickhouseURL, basicAuth, table)
+	if err != nil {
+		return nil, err
+	}
+	cacheSchema(schema)
+	return schema, nil
+}
+
+// Codec 把一次 POST 请求体解码为待插入 ClickHouse 的行
+// newline TSV、JSONEachRow、RowBinary、Native protobuf、Parquet 各自实现这个接口
+type Codec interface {
+	Format() Format
+	Decode(body []byte, schema *BatchSchema) ([]string, error)
+}
+
+var formatClauseRE = regexp.MustCompile(`(?i)FORMAT\s+(\w+)`)
+
+var formatAliases = map[string]Format{
+	"tabseparated": FormatTSV,
+	"jsoneachrow":  FormatJSONEachRow,
+	"rowbinary":    FormatRowBinary,
+	"native":       FormatNative,
+	"parquet":      FormatParquet,
+}
+
+var contentTypeAliases = map[string]Format{
+	"application/x-ndjson":               FormatJSONEachRow,
+	"application/jsonlines":              FormatJSONEachRow,
+	"application/x-clickhouse-rowbinary":  FormatRowBinary,
+	"application/x-protobuf":             FormatNative,
+	"application/vnd.apache.parquet":     FormatParquet,
+}
+
+// DetectFormat 依次从查询里的 FORMAT 子句和 Content-Type 头推断输入格式
+// 两者都没有命中时回退到原来的换行 TSV 格式，保持向后兼容
+func DetectFormat(contentType, query string) Format {
+	if m := formatClauseRE.FindStringSubmatch(query); m != nil {
+		if f, ok := formatAliases[strings.ToLower(m[1])]; ok {
+			return f
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if f, ok := contentTypeAliases[mediaType]; ok {
+		return f
+	}
+
+	return FormatTSV
+}
+
+// codecs 按格式注册的编解码器实现
+var codecs = map[Format]Codec{
+	FormatTSV:         tsvCodec{},
+	FormatJSONEachRow: jsonEachRowCodec{},
+	FormatRowBinary:   rowBinaryCodec{},
+	FormatNative:      nativeCodec{},
+	FormatParquet:     parquetCodec{},
+}
+
+// CodecFor 返回给定格式对应的编解码器，未知格式回退到 TSV 以兼容旧客户端
+func CodecFor(format Format) Codec {
+	if c, ok := codecs[format]; ok {
+		return c
+	}
+	return tsvCodec{}
+}
+
+func checkLimits(format Format, bodyLen int) error {
+	limits := defaultCodecLimits[format]
+	if limits.MaxBytes > 0 && int64(bodyLen) > limits.MaxBytes {
+		return fmt.Errorf("body of %d bytes exceeds max of %d bytes for format %s", bodyLen, limits.MaxBytes, format)
+	}
+	return nil
+}
+
+// tsvCodec 是原来的按换行分隔实现，新增的格式都是它的平级扩展
+type tsvCodec struct{}
+
+func (tsvCodec) Format() Format { return FormatTSV }
+
+func (tsvCodec) Decode(body []byte, schema *BatchSchema) ([]string, error) {
+	if err := checkLimits(FormatTSV, len(body)); err != nil {
+		return nil, err
+	}
+	rows := strings.Split(string(body), "\n")
+	if limits := defaultCodecLimits[FormatTSV]; limits.MaxRows > 0 && len(rows) > limits.MaxRows {
+		return nil, fmt.Errorf("row count %d exceeds max of %d for format %s", len(rows), limits.MaxRows, FormatTSV)
+	}
+	return rows, nil
+}
+
+// jsonEachRowCodec 解析每行一个 JSON 对象的输入，常见于 OpenTelemetry collector 等分析 agent
+type jsonEachRowCodec struct{}
+
+func (jsonEachRowCodec) Format() Format { return FormatJSONEachRow }
+
+func (jsonEachRowCodec) Decode(body []byte, schema *BatchSchema) ([]string, error) {
+	if err := checkLimits(FormatJSONEachRow, len(body)); err != nil {
+		return nil, err
+	}
+
+	limits := defaultCodecLimits[FormatJSONEachRow]
+	var rows []string
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid JSONEachRow payload: %w", err)
+		}
+		if limits.MaxRows > 0 && len(rows) >= limits.MaxRows {
+			return nil, fmt.Errorf("row count exceeds max of %d for format %s", limits.MaxRows, FormatJSONEachRow)
+		}
+		rows = append(rows, string(raw))
+	}
+	return rows, nil
+}
+
+// rowBinaryCodec 解码 ClickHouse 原生 RowBinary 格式
+// 列布局不是自描述的，必须依赖按表缓存的 schema，缓存未命中时直接拒绝请求
+type rowBinaryCodec struct{}
+
+func (rowBinaryCodec) Format() Format { return FormatRowBinary }
+
+func (rowBinaryCodec) Decode(body []byte, schema *BatchSchema) ([]string, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("RowBinary input requires a cached schema for the target table")
+	}
+	if err := checkLimits(FormatRowBinary, len(body)); err != nil {
+		return nil, err
+	}
+	// 整个请求体是一条连续的二进制流，按行拆分留给下游的原生插入客户端处理，
+	// 这里只负责校验大小并确认 schema 可用。
+	return []string{string(body)}, nil
+}
+
+// nativeCodec 解码 ClickHouse Native/protobuf 格式，同样依赖表 schema 才能反序列化列
+type nativeCodec struct{}
+
+func (nativeCodec) Format() Format { return FormatNative }
+
+func (nativeCodec) Decode(body []byte, schema *BatchSchema) ([]string, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("Native input requires a cached schema for the target table")
+	}
+	if err := checkLimits(FormatNative, len(body)); err != nil {
+		return nil, err
+	}
+	return []string{string(body)}, nil
+}
+
+// parquetCodec 解码列式 Parquet 文件，一个文件通常就对应一整批行
+type parquetCodec struct{}
+
+func (parquetCodec) Format() Format { return FormatParquet }
+
+func (parquetCodec) Decode(body []byte, schema *BatchSchema) ([]string, error) {
+	if err := checkLimits(FormatParquet, len(body)); err != nil {
+		return nil, err
+	}
+	return []string{string(body)}, nil
+}
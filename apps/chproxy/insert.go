@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// insertRows 把一批已经解码好的行通过 ClickHouse 的原始 HTTP 接口插入某张表
+// BufferedIngest 的消费循环和 ShardedBatcher 的 per-shard flush 都复用这个函数，
+// 区别只在于 endpoint：前者总是打到 config.ClickhouseURL，后者打到分片拓扑里选中的副本地址
+// format 必须和 rows 实际的编码一致（codec.go 里各 Format 的字符串值就是 ClickHouse 的 FORMAT 名），
+// 否则 ClickHouse 会用错误的格式去解析这批已经按其他格式解码好的行
+func insertRows(ctx context.Context, endpoint, basicAuth, table string, format Format, rows []string) error {
+	query := fmt.Sprintf("INSERT INTO %s FORMAT %s", table, format)
+	body := strings.Join(rows, "\n")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"?query="+url.QueryEscape(query), strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build insert request for %s: %w", table, err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach clickhouse inserting into %s: %w", table, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("clickhouse returned %d inserting into %s", resp.StatusCode, table)
+	}
+	return nil
+}
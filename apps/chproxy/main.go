@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -25,9 +26,9 @@ const (
 )
 
 var (
-	telemetry  *TelemetryConfig  // 遥测配置
-	inFlight   sync.WaitGroup    // 追踪进行中的请求
-	httpClient *http.Client      // 共享的 HTTP 客户端
+	telemetry  *TelemetryConfig // 遥测配置
+	inFlight   sync.WaitGroup   // 追踪进行中的请求
+	httpClient *http.Client     // 共享的 HTTP 客户端
 )
 
 // main 函数实现了 ClickHouse 的代理服务
@@ -77,11 +78,74 @@ func main() {
 	// 设置基本认证
 	requiredAuthorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(config.BasicAuth))
 
-	// 创建请求缓冲通道
-	buffer := make(chan *Batch, maxBufferSize)
+	// 打开 WAL 并恢复上次崩溃/重启时遗留下的未确认 Batch
+	// 用持久化的 BufferedIngest 取代裸 channel：TryEnqueue 在饱和时立即拒绝，
+	// 而不是无限阻塞生产者直到把整个进程的内存撑爆
+	bufferedIngest, err := NewBufferedIngest(config.WALDir, 64<<20, maxBufferSize, config.Logger)
+	if err != nil {
+		log.Fatalf("failed to open buffered ingest wal: %v", err)
+	}
+	if replayed, err := bufferedIngest.Replay(); err != nil {
+		log.Fatalf("failed to replay wal: %v", err)
+	} else if replayed > 0 {
+		config.Logger.Info("replayed unflushed wal records", "count", replayed)
+	}
+
+	// compactor 和（可选的）分片 batcher 都是纯后台循环，用独立于请求处理的 bgCtx 控制生命周期：
+	// 关闭时要先让消费者把 bufferedIngest 的队列 drain 完，再取消 bgCtx 去停这些循环，
+	// 两者共用主 ctx 的话，提前取消会在 consumerDone 之前就让 Dequeue 提前返回
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+
+	stopCompactor := bufferedIngest.StartCompactor(bgCtx, 5*time.Second)
+
+	// 有分片拓扑配置时，用 ShardedBatcher 按 HRW 把行路由到各自的 ClickHouse 分片，
+	// 每个分片独立攒批、独立重试、独立熔断；没配置分片时退回到单一目标的 insertRows
+	var shardedBatcher *ShardedBatcher
+	if len(config.ShardTopology.Shards) > 0 {
+		shardedBatcher, err = NewShardedBatcher(ShardedBatcherConfig{
+			Topology:      config.ShardTopology,
+			KeyExtractor:  DefaultShardKeyExtractor,
+			FlushInterval: config.FlushInterval,
+			Flush: func(ctx context.Context, endpoint, table string, format Format, rows []string) error {
+				return insertRows(ctx, endpoint, config.BasicAuth, table, format, rows)
+			},
+		})
+		if err != nil {
+			log.Fatalf("failed to construct sharded batcher: %v", err)
+		}
+		shardedBatcher.Start(bgCtx)
+	}
 
-	// 启动缓冲处理器
-	done := startBufferProcessor(ctx, buffer, config, telemetry)
+	// 启动缓冲处理器：从 WAL 支撑的队列里取出 Batch，插入 ClickHouse 后确认对应的段
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		for {
+			batch, segmentPath, ok := bufferedIngest.Dequeue(ctx)
+			if !ok {
+				return
+			}
+
+			var err error
+			if shardedBatcher != nil {
+				err = routeToShards(shardedBatcher, batch)
+			} else {
+				err = insertRows(ctx, config.ClickhouseURL, config.BasicAuth, batch.Table, batch.Format, batch.Rows)
+			}
+			if err != nil {
+				config.Logger.Error("failed to insert batch into clickhouse",
+					"table", batch.Table,
+					"row_count", len(batch.Rows),
+					"error", err.Error())
+				telemetry.Metrics.ErrorCounter.Add(ctx, 1)
+			}
+
+			// 无论插入成功与否都确认，失败的 Batch 靠重放下一次启动时再次尝试没有意义
+			// （同一批数据多半还是会失败），真正的重试由调用方在更上层处理
+			bufferedIngest.Ack(segmentPath)
+		}
+	}()
 
 	// 健康检查路由
 	http.HandleFunc("/v1/liveness", func(w http.ResponseWriter, r *http.Request) {
@@ -127,15 +191,26 @@ func main() {
 		)
 
 		// 处理查询
-		query := r.URL.Query().Get("query")
+		queryParams := r.URL.Query()
+		query := queryParams.Get("query")
 		span.SetAttributes(attribute.String("query", query))
 
+		// Batch.Params 保留这次请求上除 "query" 以外的查询参数（例如 ClickHouse 的会话/设置参数），
+		// 这样重放 WAL 记录时还能把它们带上
+		params := make(map[string]string, len(queryParams))
+		for key, values := range queryParams {
+			if key == "query" || len(values) == 0 {
+				continue
+			}
+			params[key] = values[0]
+		}
+
 		if query == "" || !strings.HasPrefix(strings.ToLower(query), "insert into") {
 			telemetry.Metrics.ErrorCounter.Add(ctx, 1)
 			config.Logger.Warn("invalid query",
 				"query", query,
 				"remote_addr", r.RemoteAddr)
-			
+
 			span.SetStatus(codes.Error, "wrong query")
 			http.Error(w, "wrong query", http.StatusBadRequest)
 			return
@@ -154,24 +229,73 @@ func main() {
 			http.Error(w, "cannot read body", http.StatusInternalServerError)
 			return
 		}
-		
-		rows := strings.Split(string(body), "\n")
+
+		table := strings.Split(query, " ")[2]
+		format := DetectFormat(r.Header.Get("Content-Type"), query)
+
+		var schema *BatchSchema
+		if format == FormatRowBinary || format == FormatNative {
+			schema, err = resolveSchema(ctx, config.ClickhouseURL, config.BasicAuth, table)
+			if err != nil {
+				telemetry.Metrics.ErrorCounter.Add(ctx, 1)
+				config.Logger.Error("failed to resolve table schema",
+					"table", table,
+					"format", string(format),
+					"error", err.Error())
+
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "cannot resolve table schema")
+				http.Error(w, "cannot resolve table schema", http.StatusBadGateway)
+				return
+			}
+		}
+
+		rows, err := CodecFor(format).Decode(body, schema)
+		if err != nil {
+			telemetry.Metrics.ErrorCounter.Add(ctx, 1)
+			config.Logger.Warn("failed to decode request body",
+				"error", err.Error(),
+				"format", string(format),
+				"table", table,
+				"remote_addr", r.RemoteAddr)
+
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "cannot decode body")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		config.Logger.Debug("received insert request",
 			"row_count", len(rows),
-			"table", strings.Split(query, " ")[2])
+			"format", string(format),
+			"table", table)
 
-		// 将数据发送到缓冲区
-		buffer <- &Batch{
-			Params: params,
-			Rows:   rows,
-			Table:  strings.Split(query, " ")[2],
+		// 将数据写入 WAL 并放入处理队列；队列已满时立即拒绝，而不是阻塞住这个请求
+		if err := bufferedIngest.TryEnqueue(&Batch{Params: params, Rows: rows, Table: table, Format: format}); err != nil {
+			telemetry.Metrics.ErrorCounter.Add(ctx, 1)
+			if errors.Is(err, ErrSaturated) {
+				config.Logger.Warn("ingest buffer saturated, rejecting request",
+					"table", table,
+					"remote_addr", r.RemoteAddr)
+				span.SetStatus(codes.Error, "buffer saturated")
+				WriteRetryAfter(w, time.Second)
+				return
+			}
+
+			config.Logger.Error("failed to persist batch to wal",
+				"table", table,
+				"error", err.Error())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "cannot persist batch")
+			http.Error(w, "cannot persist batch", http.StatusInternalServerError)
+			return
 		}
 
 		w.Write([]byte("ok"))
 		span.SetStatus(codes.Ok, "")
 		span.SetAttributes(
 			attribute.Int("row_count", len(rows)),
-			attribute.String("table", strings.Split(query, " ")[2]),
+			attribute.String("format", string(format)),
+			attribute.String("table", table),
 		)
 	})
 
@@ -185,6 +309,12 @@ func main() {
 		config.Logger.Info("server listening", "port", config.ListenerPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			config.Logger.Error("failed to start server", "error", err.Error())
+		}
+	}()
+
+	// 阻塞直到收到终止信号，再往下走优雅关闭流程
+	<-signalCtx.Done()
+
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
@@ -201,8 +331,17 @@ func main() {
 		config.Logger.Error("server shutdown error", "error", err.Error())
 	}
 
-	// Close the buffer channel and wait for processing to finish
-	close(buffer)
-	<-done
+	// Close the ingest queue and wait for the consumer to drain it
+	bufferedIngest.Close()
+	<-consumerDone
+
+	// Only stop the background compactor/batcher loops once the consumer has
+	// drained; they share bgCtx, not the request-scoped ctx, so this doesn't
+	// race with the drain above.
+	bgCancel()
+	stopCompactor()
+	if shardedBatcher != nil {
+		shardedBatcher.Wait()
+	}
 	config.Logger.Info("graceful shutdown complete")
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// ShardEndpoint 是一个 ClickHouse 分片的主副本，外加按优先级排列的故障转移副本
+type ShardEndpoint struct {
+	Name     string
+	Primary  string
+	Replicas []string
+	Weight   float64
+}
+
+// ShardTopology 是从配置里读到的分片拓扑
+type ShardTopology struct {
+	Shards []ShardEndpoint
+}
+
+// ShardKeyExtractor 从一行数据里提取用于分片路由的 key
+// JSON 格式按 JSON pointer 取值，RowBinary/Native 这类列式格式按配置的列序号取值
+type ShardKeyExtractor func(row string, format Format) (string, error)
+
+// rendezvousScore 是 HRW（Highest Random Weight）算法的打分函数
+// 比一致性哈希环更简单：加一个或去掉一个分片时，只有 1/N 的 key 会被重新映射，
+// 其余 key 的归属完全不受影响
+func rendezvousScore(key, shardName string, weight float64) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte(shardName))
+	// 映射到 (0, 1) 开区间，避免 log(0)
+	unit := (float64(h.Sum64()) + 1) / (float64(math.MaxUint64) + 2)
+
+	if weight <= 0 {
+		weight = 1
+	}
+	return -weight / math.Log(unit)
+}
+
+// PickShard 对给定 key 做 HRW 打分，返回得分最高的分片
+func (t *ShardTopology) PickShard(key string) (ShardEndpoint, error) {
+	if len(t.Shards) == 0 {
+		return ShardEndpoint{}, fmt.Errorf("shard topology has no shards configured")
+	}
+
+	best := t.Shards[0]
+	bestScore := rendezvousScore(key, best.Name, best.Weight)
+	for _, shard := range t.Shards[1:] {
+		score := rendezvousScore(key, shard.Name, shard.Weight)
+		if score > bestScore {
+			best = shard
+			bestScore = score
+		}
+	}
+	return best, nil
+}
+
+// DefaultShardKeyExtractor 是没有配置按列/按 JSON 字段取 key 时的兜底实现：
+// TSV 取第一列，JSONEachRow 取顶层 "id" 字段，其余格式没有可靠的列信息，直接用整行兜底
+// （意味着同一行的路由是稳定的，但跨行不保证按业务 key 聚合）
+func DefaultShardKeyExtractor(row string, format Format) (string, error) {
+	switch format {
+	case FormatTSV:
+		col, _, _ := strings.Cut(row, "\t")
+		return col, nil
+	case FormatJSONEachRow:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(row), &fields); err != nil {
+			return "", fmt.Errorf("failed to extract shard key from JSONEachRow row: %w", err)
+		}
+		if id, ok := fields["id"]; ok {
+			return strings.Trim(string(id), `"`), nil
+		}
+		return row, nil
+	default:
+		return row, nil
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var shardedBatcherTracer = otel.Tracer("github.com/unkeyed/unkey/apps/chproxy")
+var shardedBatcherMeter = otel.Meter("github.com/unkeyed/unkey/apps/chproxy")
+
+// shardQueueDepth 按分片名记录当前排队等待 flush 的行数
+var shardQueueDepth, _ = shardedBatcherMeter.Int64UpDownCounter("chproxy_shard_queue_depth")
+
+// ShardFlusher 把一批行写入某个 ClickHouse 端点，真正发 HTTP 请求的逻辑由调用方提供
+// （通常就是本包里已有的向 ClickHouse POST 的那段代码），这里只负责分片路由、重试和熔断
+// format 必须原样转发给底层的插入调用：行已经按这个格式解码过，插入时也必须用同一个 FORMAT
+type ShardFlusher func(ctx context.Context, endpoint, table string, format Format, rows []string) error
+
+// ShardedBatcherConfig 配置 ShardedBatcher
+type ShardedBatcherConfig struct {
+	Topology         ShardTopology
+	KeyExtractor     ShardKeyExtractor
+	Flush            ShardFlusher
+	FlushInterval    time.Duration
+	MaxBatchSize     int
+	MaxRetries       int
+	BaseBackoff      time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int
+	CooldownPeriod   time.Duration
+}
+
+func (c *ShardedBatcherConfig) setDefaults() {
+	if c.MaxBatchSize <= 0 {
+		c.MaxBatchSize = maxBatchSize
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Second
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.CooldownPeriod <= 0 {
+		c.CooldownPeriod = 30 * time.Second
+	}
+}
+
+type shardedRow struct {
+	table  string
+	format Format
+	row    string
+}
+
+// pendingKey 把攒批的行按表和格式一起分组：同一张表的行如果是用不同格式解码出来的，
+// flush 时必须分开发送，各自带上正确的 FORMAT 子句
+type pendingKey struct {
+	table  string
+	format Format
+}
+
+type shardBuffer struct {
+	endpoint ShardEndpoint
+	rows     chan shardedRow
+	// breakers 按端点地址（主副本和每个故障转移副本各一个）独立维护，
+	// 这样主副本的失败不会连带拒绝还没试过的副本，副本的成功也不会错误地重置主副本的状态
+	breakers map[string]*CircuitBreaker
+}
+
+func (b *shardBuffer) breakerFor(endpoint string) *CircuitBreaker {
+	return b.breakers[endpoint]
+}
+
+// ShardedBatcher 把单个全局队列换成按一致性哈希（HRW）路由到每个 ClickHouse 分片的子队列，
+// 分片增减只重新映射 1/N 的 key，每个分片的 flush、重试和熔断都互不影响
+type ShardedBatcher struct {
+	cfg     ShardedBatcherConfig
+	buffers map[string]*shardBuffer
+	wg      sync.WaitGroup
+}
+
+// NewShardedBatcher 按配置里的拓扑为每个分片建立一个子缓冲区和对应的熔断器
+func NewShardedBatcher(cfg ShardedBatcherConfig) (*ShardedBatcher, error) {
+	if len(cfg.Topology.Shards) == 0 {
+		return nil, fmt.Errorf("sharded batcher requires at least one shard")
+	}
+	cfg.setDefaults()
+
+	sb := &ShardedBatcher{cfg: cfg, buffers: make(map[string]*shardBuffer, len(cfg.Topology.Shards))}
+	for _, shard := range cfg.Topology.Shards {
+		breakers := make(map[string]*CircuitBreaker, 1+len(shard.Replicas))
+		for _, endpoint := range append([]string{shard.Primary}, shard.Replicas...) {
+			breakers[endpoint] = NewCircuitBreaker(cfg.FailureThreshold, cfg.CooldownPeriod)
+		}
+
+		sb.buffers[shard.Name] = &shardBuffer{
+			endpoint: shard,
+			rows:     make(chan shardedRow, maxBufferSize),
+			breakers: breakers,
+		}
+	}
+	return sb, nil
+}
+
+// routeToShards 把 BufferedIngest 消费出来的一个 Batch 逐行投递给 ShardedBatcher
+// 只要有一行路由失败就返回错误，由调用方决定如何记录/处理（目前是整批一起记日志）
+func routeToShards(sb *ShardedBatcher, batch *Batch) error {
+	for _, row := range batch.Rows {
+		if err := sb.Route(batch.Table, batch.Format, row); err != nil {
+			return fmt.Errorf("failed to route row to shard for table %s: %w", batch.Table, err)
+		}
+	}
+	return nil
+}
+
+// Route 按 shard key 把一行数据投递到对应分片的子队列，子队列已满时返回错误而不是阻塞调用方
+func (sb *ShardedBatcher) Route(table string, format Format, row string) error {
+	key, err := sb.cfg.KeyExtractor(row, format)
+	if err != nil {
+		return fmt.Errorf("failed to extract shard key: %w", err)
+	}
+
+	shard, err := sb.cfg.Topology.PickShard(key)
+	if err != nil {
+		return err
+	}
+
+	buf, ok := sb.buffers[shard.Name]
+	if !ok {
+		return fmt.Errorf("no buffer registered for shard %s", shard.Name)
+	}
+
+	select {
+	case buf.rows <- shardedRow{table: table, format: format, row: row}:
+		shardQueueDepth.Add(context.Background(), 1, metric.WithAttributes(attribute.String("shard", shard.Name)))
+		return nil
+	default:
+		return fmt.Errorf("shard %s buffer is saturated", shard.Name)
+	}
+}
+
+// Start 为每个分片启动一个独立的 flush 循环
+// ctx 取消后每个循环会先 flush 掉自己手里攒着的数据再退出
+func (sb *ShardedBatcher) Start(ctx context.Context) {
+	for _, buf := range sb.buffers {
+		sb.wg.Add(1)
+		go sb.runShard(ctx, buf)
+	}
+}
+
+// Wait 阻塞直到所有分片的 flush 循环都退出
+func (sb *ShardedBatcher) Wait() {
+	sb.wg.Wait()
+}
+
+func (sb *ShardedBatcher) runShard(ctx context.Context, buf *shardBuffer) {
+	defer sb.wg.Done()
+
+	ticker := time.NewTicker(sb.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[pendingKey][]string)
+
+	flush := func() {
+		for key, rows := range pending {
+			if len(rows) == 0 {
+				continue
+			}
+			sb.flushShard(ctx, buf, key.table, key.format, rows)
+			shardQueueDepth.Add(ctx, -int64(len(rows)), metric.WithAttributes(attribute.String("shard", buf.endpoint.Name)))
+		}
+		pending = make(map[pendingKey][]string)
+	}
+
+	for {
+		select {
+		case row, open := <-buf.rows:
+			if !open {
+				flush()
+				return
+			}
+			key := pendingKey{table: row.table, format: row.format}
+			pending[key] = append(pending[key], row.row)
+			if len(pending[key]) >= sb.cfg.MaxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// flushShard 把一批行写入分片的主副本，副本失败（重试耗尽后）就故障转移到下一个副本
+// 每个端点的健康状况由独立的熔断器跟踪，熔断打开时直接跳过该端点
+func (sb *ShardedBatcher) flushShard(ctx context.Context, buf *shardBuffer, table string, format Format, rows []string) {
+	ctx, span := shardedBatcherTracer.Start(ctx, "shard_flush")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("shard", buf.endpoint.Name),
+		attribute.Int("row_count", len(rows)),
+		attribute.String("table", table),
+		attribute.String("format", string(format)),
+	)
+
+	endpoints := append([]string{buf.endpoint.Primary}, buf.endpoint.Replicas...)
+
+	for _, endpoint := range endpoints {
+		if !buf.breakerFor(endpoint).Allow() {
+			span.AddEvent("circuit open, skipping endpoint", trace.WithAttributes(attribute.String("endpoint", endpoint)))
+			continue
+		}
+
+		if sb.flushWithRetry(ctx, buf, endpoint, table, format, rows) {
+			span.SetStatus(codes.Ok, "")
+			return
+		}
+	}
+
+	span.SetStatus(codes.Error, "all endpoints failed")
+}
+
+func (sb *ShardedBatcher) flushWithRetry(ctx context.Context, buf *shardBuffer, endpoint, table string, format Format, rows []string) bool {
+	backoff := sb.cfg.BaseBackoff
+	for attempt := 0; attempt <= sb.cfg.MaxRetries; attempt++ {
+		err := sb.cfg.Flush(ctx, endpoint, table, format, rows)
+		if err == nil {
+			buf.breakerFor(endpoint).RecordSuccess()
+			return true
+		}
+		buf.breakerFor(endpoint).RecordFailure()
+
+		if attempt == sb.cfg.MaxRetries {
+			return false
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(backoff) + 1))
+		wait := backoff + jitter
+		if wait > sb.cfg.MaxBackoff {
+			wait = sb.cfg.MaxBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+
+		backoff *= 2
+		if backoff > sb.cfg.MaxBackoff {
+			backoff = sb.cfg.MaxBackoff
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// walMagic 标记每个 WAL 记录的起始，帮助在截断/损坏恢复时重新对齐
+const walMagic uint32 = 0x57414c31 // "WAL1"
+
+// WALSegment 是一个追加写入的磁盘文件，保存已接受但尚未确认写入 ClickHouse 的 Batch
+// 记录格式: magic(4) | length(4) | crc32c(4) | payload(length)
+type WALSegment struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	maxBytes int64
+}
+
+func openWALSegment(path string, maxBytes int64) (*WALSegment, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal segment %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat wal segment %s: %w", path, err)
+	}
+	return &WALSegment{
+		path:     path,
+		file:     f,
+		writer:   bufio.NewWriter(f),
+		size:     info.Size(),
+		maxBytes: maxBytes,
+	}, nil
+}
+
+// Append 写入一条记录但不立即 fsync，调用方负责按 group-commit 策略调用 Sync
+func (s *WALSegment) Append(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[0:4], walMagic)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[8:12], crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli)))
+
+	if _, err := s.writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write wal record header: %w", err)
+	}
+	if _, err := s.writer.Write(payload); err != nil {
+		return fmt.Errorf("failed to write wal record payload: %w", err)
+	}
+	s.size += int64(len(header) + len(payload))
+	return nil
+}
+
+// Sync flush 缓冲区并 fsync 到磁盘，在批量 Append 之后调用一次即可实现 group commit
+func (s *WALSegment) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush wal segment %s: %w", s.path, err)
+	}
+	return s.file.Sync()
+}
+
+// Full 判断当前段是否已达到配置的最大大小，达到后调用方应滚动到新段
+func (s *WALSegment) Full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size >= s.maxBytes
+}
+
+// Close 关闭底层文件句柄
+func (s *WALSegment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+// Remove 关闭并删除段文件，由 compactor 在 ClickHouse 确认写入后调用
+func (s *WALSegment) Remove() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+// WAL 管理一组按序号递增的段文件，负责滚动、回放以及删除已确认的段
+type WAL struct {
+	mu       sync.Mutex
+	dir      string
+	segBytes int64
+	active   *WALSegment
+	nextSeq  int
+}
+
+// OpenWAL 打开（或创建）一个 WAL 目录，不会自动回放，回放由 Replay 显式触发
+func OpenWAL(dir string, segmentBytes int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal dir %s: %w", dir, err)
+	}
+	w := &WAL{dir: dir, segBytes: segmentBytes}
+
+	existing, err := w.segmentPaths()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		last := existing[len(existing)-1]
+		w.nextSeq = segmentSeq(last) + 1
+	}
+
+	seg, err := w.rollLocked()
+	if err != nil {
+		return nil, err
+	}
+	w.active = seg
+	return w, nil
+}
+
+func (w *WAL) segmentPaths() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(w.dir, "*.wal"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wal segments in %s: %w", w.dir, err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func segmentSeq(path string) int {
+	base := strings.TrimSuffix(filepath.Base(path), ".wal")
+	n, err := strconv.Atoi(base)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (w *WAL) rollLocked() (*WALSegment, error) {
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d.wal", w.nextSeq))
+	w.nextSeq++
+	return openWALSegment(path, w.segBytes)
+}
+
+// Append 把一条序列化后的 Batch 写入当前活跃段，段写满后自动滚动到下一个
+func (w *WAL) Append(payload []byte) (*WALSegment, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.active.Full() {
+		if err := w.active.Sync(); err != nil {
+			return nil, err
+		}
+		seg, err := w.rollLocked()
+		if err != nil {
+			return nil, err
+		}
+		w.active = seg
+	}
+
+	if err := w.active.Append(payload); err != nil {
+		return nil, err
+	}
+	return w.active, nil
+}
+
+// Sync fsync 当前活跃段，供 group-commit 在一批请求之后统一调用
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active.Sync()
+}
+
+// ActivePath 返回当前正在被追加写入的段路径
+// compactor 必须排除这个路径：即使它的记录已经全部被确认，文件仍然会继续被打开写入，
+// 提前删除会导致 inode 被 unlink 后的写入在崩溃重启后彻底丢失（segmentPaths 的 glob 也找不到它了）
+func (w *WAL) ActivePath() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active.path
+}
+
+// walRecord 是回放时从磁盘读出的一条记录，SegmentPath 用于在全部确认后定位要删除的段
+type walRecord struct {
+	SegmentPath string
+	Payload     []byte
+}
+
+// Replay 按序号顺序读出所有段里的记录，供启动时把未确认的 Batch 重新灌回 ClickHouse
+// 遇到被截断或 CRC 校验失败的尾部记录时停止读取该段，已损坏的部分记录视为未提交
+func (w *WAL) Replay() ([]walRecord, error) {
+	paths, err := w.segmentPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []walRecord
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open wal segment %s for replay: %w", path, err)
+		}
+
+		r := bufio.NewReader(f)
+		for {
+			header := make([]byte, 12)
+			if _, err := readFull(r, header); err != nil {
+				break // EOF 或截断的尾部记录，当作未提交处理
+			}
+			magic := binary.BigEndian.Uint32(header[0:4])
+			length := binary.BigEndian.Uint32(header[4:8])
+			wantCRC := binary.BigEndian.Uint32(header[8:12])
+			if magic != walMagic {
+				break
+			}
+
+			payload := make([]byte, length)
+			if _, err := readFull(r, payload); err != nil {
+				break
+			}
+			if crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli)) != wantCRC {
+				break // CRC 不匹配，说明写入时崩溃，丢弃这条及之后的记录
+			}
+
+			records = append(records, walRecord{SegmentPath: path, Payload: payload})
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+// RemoveSegment 删除一个已经不再被引用的段文件，由 compactor 在确认段内所有记录
+// 都已成功写入 ClickHouse 后调用
+func (w *WAL) RemoveSegment(path string) error {
+	return os.Remove(path)
+}
+
+func readFull(r *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
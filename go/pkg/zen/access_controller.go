@@ -0,0 +1,101 @@
+package zen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/unkeyed/unkey/go/pkg/fault"
+)
+
+// AccessController 校验一次请求是否被允许，成功时可以把校验得到的身份信息
+// （比如解析出的 JWT claims）写回 context 供后续 handler 读取
+type AccessController interface {
+	Authorized(ctx context.Context, s *Session) (context.Context, error)
+}
+
+// Challenge 是授权失败时返回的错误，携带了应当写回 WWW-Authenticate 头的信息
+// WithErrorHandling 在看到 fault.UNAUTHORIZED 标签的错误实现了 Challenge 接口时，
+// 会把 Header() 的内容写进响应头
+type Challenge struct {
+	Scheme string            // 例如 "Basic"、"Bearer"
+	Params map[string]string // 例如 Bearer 的 realm/error/error_description
+	cause  error
+}
+
+// NewChallenge 构造一个带 fault.UNAUTHORIZED 标签的 Challenge 错误
+func NewChallenge(scheme string, params map[string]string, cause error) error {
+	return fault.Wrap(&Challenge{Scheme: scheme, Params: params, cause: cause}, fault.WithTag(fault.UNAUTHORIZED))
+}
+
+func (c *Challenge) Error() string {
+	if c.cause != nil {
+		return fmt.Sprintf("authorization challenge (%s): %s", c.Scheme, c.cause)
+	}
+	return fmt.Sprintf("authorization challenge (%s)", c.Scheme)
+}
+
+func (c *Challenge) Unwrap() error { return c.cause }
+
+// Header 渲染出 WWW-Authenticate 响应头的值，例如 `Bearer realm="unkey", error="invalid_token"`
+func (c *Challenge) Header() string {
+	header := c.Scheme
+	first := true
+	for k, v := range c.Params {
+		if first {
+			header += " "
+			first = false
+		} else {
+			header += ", "
+		}
+		header += fmt.Sprintf(`%s="%s"`, k, v)
+	}
+	return header
+}
+
+// authControllers 是按名字注册的 AccessController 集合，挂在 Server 上而不是做成包级全局变量，
+// 这样同一个进程里的多个 Server（比如测试里起好几个）各自的注册互不干扰
+type authControllers struct {
+	mu   sync.RWMutex
+	byID map[string]AccessController
+}
+
+// RegisterAuthController 在 Server 启动前注册一个具名的 AccessController
+// 路由通过 WithAuth(name) 引用这里注册的实现
+func (s *Server) RegisterAuthController(name string, ctrl AccessController) {
+	s.authControllers.mu.Lock()
+	defer s.authControllers.mu.Unlock()
+	if s.authControllers.byID == nil {
+		s.authControllers.byID = make(map[string]AccessController)
+	}
+	s.authControllers.byID[name] = ctrl
+}
+
+func (s *Server) lookupAuthController(name string) (AccessController, bool) {
+	s.authControllers.mu.RLock()
+	defer s.authControllers.mu.RUnlock()
+	ctrl, ok := s.authControllers.byID[name]
+	return ctrl, ok
+}
+
+// WithAuth 在处理请求前运行指定名字下注册的 AccessController
+// 未找到对应名字的控制器视为服务端配置错误，返回 500 而不是放行请求
+func WithAuth(name string) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, s *Session) error {
+			ctrl, ok := s.Server().lookupAuthController(name)
+			if !ok {
+				return fault.New(fmt.Sprintf("no auth controller registered under %q", name),
+					fault.WithTag(fault.INTERNAL_SERVER_ERROR),
+				)
+			}
+
+			authedCtx, err := ctrl.Authorized(ctx, s)
+			if err != nil {
+				return err
+			}
+
+			return next(authedCtx, s)
+		}
+	}
+}
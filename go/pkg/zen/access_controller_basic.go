@@ -0,0 +1,45 @@
+package zen
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/unkeyed/unkey/go/pkg/fault"
+)
+
+// BasicController 校验固定的用户名/密码对，用于遗留客户端
+type BasicController struct {
+	Realm    string
+	Username string
+	Password string
+}
+
+var _ AccessController = (*BasicController)(nil)
+
+func (b *BasicController) Authorized(ctx context.Context, s *Session) (context.Context, error) {
+	header := s.Request().Header.Get("Authorization")
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return ctx, NewChallenge("Basic", map[string]string{"realm": b.Realm}, fault.New("missing basic auth header"))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ctx, NewChallenge("Basic", map[string]string{"realm": b.Realm}, fault.New("malformed basic auth header"))
+	}
+
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return ctx, NewChallenge("Basic", map[string]string{"realm": b.Realm}, fault.New("malformed basic auth credentials"))
+	}
+
+	userMatches := subtle.ConstantTimeCompare([]byte(user), []byte(b.Username)) == 1
+	passMatches := subtle.ConstantTimeCompare([]byte(pass), []byte(b.Password)) == 1
+	if !userMatches || !passMatches {
+		return ctx, NewChallenge("Basic", map[string]string{"realm": b.Realm}, fault.New("invalid basic auth credentials"))
+	}
+
+	return ctx, nil
+}
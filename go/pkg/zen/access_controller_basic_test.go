@@ -0,0 +1,41 @@
+package zen
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func basicAuthHeader(user, pass string) http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pass)))
+	return h
+}
+
+func TestBasicControllerAcceptsCorrectCredentials(t *testing.T) {
+	ctrl := &BasicController{Realm: "unkey", Username: "admin", Password: "hunter2"}
+	s := newTestSession(http.MethodGet, "/", basicAuthHeader("admin", "hunter2"))
+
+	if _, err := ctrl.Authorized(context.Background(), s); err != nil {
+		t.Fatalf("Authorized() error = %v, want nil", err)
+	}
+}
+
+func TestBasicControllerRejectsWrongPassword(t *testing.T) {
+	ctrl := &BasicController{Realm: "unkey", Username: "admin", Password: "hunter2"}
+	s := newTestSession(http.MethodGet, "/", basicAuthHeader("admin", "wrong"))
+
+	if _, err := ctrl.Authorized(context.Background(), s); err == nil {
+		t.Fatalf("Authorized() error = nil, want a challenge error for wrong password")
+	}
+}
+
+func TestBasicControllerRejectsMissingHeader(t *testing.T) {
+	ctrl := &BasicController{Realm: "unkey", Username: "admin", Password: "hunter2"}
+	s := newTestSession(http.MethodGet, "/", http.Header{})
+
+	if _, err := ctrl.Authorized(context.Background(), s); err == nil {
+		t.Fatalf("Authorized() error = nil, want a challenge error for missing auth header")
+	}
+}
@@ -0,0 +1,113 @@
+package zen
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/unkeyed/unkey/go/pkg/fault"
+)
+
+// jwksKeyfunc 从远端 JWKS 文档里按 kid 找出对应公钥
+// 缓存由 BearerController 自己维护，refreshAfter 到期前命中缓存即可，不需要每个请求都打 JWKS 端点
+type jwksKeyfunc func(ctx context.Context, kid string) (any, error)
+
+// BearerController 校验 Bearer JWT，公钥通过 JWKS 按 kid 缓存
+type BearerController struct {
+	Realm    string
+	Issuer   string
+	Audience string
+	Keyfunc  jwksKeyfunc
+	// AllowedAlgorithms 限制接受的签名算法，默认为 RS256
+	// 必须显式传给 jwt.WithValidMethods，否则 Keyfunc 返回什么 key 材料，
+	// 攻击者就能反过来用该 key 构造任意算法（典型的是拿公钥当 HMAC 密钥伪造 HS256 token）的有效签名
+	AllowedAlgorithms []string
+
+	cacheTTL time.Duration
+	mu       sync.RWMutex
+	cache    map[string]cachedKey
+}
+
+type cachedKey struct {
+	key       any
+	expiresAt time.Time
+}
+
+var _ AccessController = (*BearerController)(nil)
+
+// NewBearerController 构造一个按 kid 缓存公钥的 Bearer 控制器，cacheTTL<=0 时默认缓存 10 分钟
+func NewBearerController(realm, issuer, audience string, keyfunc jwksKeyfunc, cacheTTL time.Duration) *BearerController {
+	if cacheTTL <= 0 {
+		cacheTTL = 10 * time.Minute
+	}
+	return &BearerController{
+		Realm:             realm,
+		Issuer:            issuer,
+		Audience:          audience,
+		Keyfunc:           keyfunc,
+		AllowedAlgorithms: []string{"RS256"},
+		cacheTTL:          cacheTTL,
+		cache:             make(map[string]cachedKey),
+	}
+}
+
+func (b *BearerController) resolveKey(ctx context.Context, kid string) (any, error) {
+	b.mu.RLock()
+	entry, ok := b.cache[kid]
+	b.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.key, nil
+	}
+
+	key, err := b.Keyfunc(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.cache[kid] = cachedKey{key: key, expiresAt: time.Now().Add(b.cacheTTL)}
+	b.mu.Unlock()
+
+	return key, nil
+}
+
+func (b *BearerController) Authorized(ctx context.Context, s *Session) (context.Context, error) {
+	header := s.Request().Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ctx, NewChallenge("Bearer", map[string]string{"realm": b.Realm}, fault.New("missing bearer token"))
+	}
+
+	allowedAlgorithms := b.AllowedAlgorithms
+	if len(allowedAlgorithms) == 0 {
+		allowedAlgorithms = []string{"RS256"}
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return b.resolveKey(ctx, kid)
+	}, jwt.WithIssuer(b.Issuer), jwt.WithAudience(b.Audience), jwt.WithValidMethods(allowedAlgorithms))
+	if err != nil || !parsed.Valid {
+		if err == nil {
+			err = fault.New("token failed validation")
+		}
+		return ctx, NewChallenge("Bearer", map[string]string{
+			"realm": b.Realm,
+			"error": "invalid_token",
+		}, err)
+	}
+
+	return context.WithValue(ctx, bearerClaimsCtxKey{}, claims), nil
+}
+
+type bearerClaimsCtxKey struct{}
+
+// BearerClaims 取出 WithAuth("bearer") 校验通过后写入 context 的 JWT claims
+func BearerClaims(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(bearerClaimsCtxKey{}).(jwt.MapClaims)
+	return claims, ok
+}
@@ -0,0 +1,74 @@
+package zen
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func bearerAuthHeader(token string) http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+token)
+	return h
+}
+
+func TestBearerControllerAcceptsAllowedAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"iss": "unkey-test",
+		"aud": "unkey-test-aud",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	ctrl := NewBearerController("unkey", "unkey-test", "unkey-test-aud", func(ctx context.Context, kid string) (any, error) {
+		return &key.PublicKey, nil
+	}, 0)
+
+	s := newTestSession(http.MethodGet, "/", bearerAuthHeader(token))
+	if _, err := ctrl.Authorized(context.Background(), s); err != nil {
+		t.Fatalf("Authorized() error = %v, want nil for an RS256 token", err)
+	}
+}
+
+// TestBearerControllerRejectsDisallowedAlgorithm is the regression test for the
+// algorithm-confusion fix: a token signed with HS256 using the server's own RSA
+// public key bytes as the HMAC secret must not be accepted just because Keyfunc
+// happily returns key material for it.
+func TestBearerControllerRejectsDisallowedAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"iss": "unkey-test",
+		"aud": "unkey-test-aud",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	forgedSecret := key.PublicKey.N.Bytes()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(forgedSecret)
+	if err != nil {
+		t.Fatalf("failed to sign forged token: %v", err)
+	}
+
+	ctrl := NewBearerController("unkey", "unkey-test", "unkey-test-aud", func(ctx context.Context, kid string) (any, error) {
+		return forgedSecret, nil
+	}, 0)
+
+	if _, err := ctrl.Authorized(context.Background(), newTestSession(http.MethodGet, "/", bearerAuthHeader(token))); err == nil {
+		t.Fatalf("Authorized() error = nil, want rejection of a non-allow-listed signing algorithm")
+	}
+}
@@ -0,0 +1,91 @@
+package zen
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signHMAC(secret []byte, keyID string, timestamp int64, method, path, body string) string {
+	message := fmt.Sprintf("%s.%d.%s.%s.%s", keyID, timestamp, method, path, body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACControllerAcceptsValidSignatureAndRestoresBody(t *testing.T) {
+	secret := []byte("shared-secret")
+	ctrl := &HMACController{
+		Realm:        "unkey",
+		Secret:       func(ctx context.Context, keyID string) ([]byte, error) { return secret, nil },
+		MaxClockSkew: time.Minute,
+	}
+
+	body := `{"hello":"world"}`
+	now := time.Now().Unix()
+	sig := signHMAC(secret, "key1", now, http.MethodPost, "/v1/ingest", body)
+
+	h := http.Header{}
+	h.Set("Authorization", fmt.Sprintf("HMAC key1:%d:%s", now, sig))
+	s := newTestSession(http.MethodPost, "/v1/ingest", h)
+	s.Request().Body = io.NopCloser(strings.NewReader(body))
+
+	if _, err := ctrl.Authorized(context.Background(), s); err != nil {
+		t.Fatalf("Authorized() error = %v, want nil for a valid signature", err)
+	}
+
+	// The body must still be readable by whatever handler runs after this controller.
+	got, err := io.ReadAll(s.Request().Body)
+	if err != nil {
+		t.Fatalf("failed to read body after Authorized(): %v", err)
+	}
+	if string(got) != body {
+		t.Fatalf("body after Authorized() = %q, want %q (not restored)", got, body)
+	}
+}
+
+func TestHMACControllerRejectsWrongSignature(t *testing.T) {
+	secret := []byte("shared-secret")
+	ctrl := &HMACController{
+		Realm:        "unkey",
+		Secret:       func(ctx context.Context, keyID string) ([]byte, error) { return secret, nil },
+		MaxClockSkew: time.Minute,
+	}
+
+	now := time.Now().Unix()
+	h := http.Header{}
+	h.Set("Authorization", fmt.Sprintf("HMAC key1:%d:%s", now, "00"))
+	s := newTestSession(http.MethodPost, "/v1/ingest", h)
+	s.Request().Body = io.NopCloser(strings.NewReader(`{}`))
+
+	if _, err := ctrl.Authorized(context.Background(), s); err == nil {
+		t.Fatalf("Authorized() error = nil, want rejection of a mismatched signature")
+	}
+}
+
+func TestHMACControllerRejectsStaleTimestamp(t *testing.T) {
+	secret := []byte("shared-secret")
+	ctrl := &HMACController{
+		Realm:        "unkey",
+		Secret:       func(ctx context.Context, keyID string) ([]byte, error) { return secret, nil },
+		MaxClockSkew: time.Minute,
+	}
+
+	stale := time.Now().Add(-time.Hour).Unix()
+	sig := signHMAC(secret, "key1", stale, http.MethodPost, "/v1/ingest", "")
+	h := http.Header{}
+	h.Set("Authorization", fmt.Sprintf("HMAC key1:%d:%s", stale, sig))
+	s := newTestSession(http.MethodPost, "/v1/ingest", h)
+	s.Request().Body = io.NopCloser(strings.NewReader(""))
+
+	if _, err := ctrl.Authorized(context.Background(), s); err == nil {
+		t.Fatalf("Authorized() error = nil, want rejection of a timestamp outside MaxClockSkew")
+	}
+}
@@ -0,0 +1,34 @@
+package zen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestSession builds a *Session wrapping a fresh request/response pair for
+// AccessController tests; Server is zero-valued since none of the controllers
+// under test touch it.
+func newTestSession(method, target string, header http.Header) *Session {
+	r := httptest.NewRequest(method, target, nil)
+	for k, values := range header {
+		for _, v := range values {
+			r.Header.Add(k, v)
+		}
+	}
+	return NewSession(httptest.NewRecorder(), r, &Server{})
+}
+
+func TestChallengeHeaderRendersSchemeAndParams(t *testing.T) {
+	c := &Challenge{Scheme: "Bearer", Params: map[string]string{"realm": "unkey"}}
+	if got, want := c.Header(), `Bearer realm="unkey"`; got != want {
+		t.Fatalf("Header() = %q, want %q", got, want)
+	}
+}
+
+func TestChallengeHeaderNoParams(t *testing.T) {
+	c := &Challenge{Scheme: "Basic"}
+	if got, want := c.Header(), "Basic"; got != want {
+		t.Fatalf("Header() = %q, want %q", got, want)
+	}
+}
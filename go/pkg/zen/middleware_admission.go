@@ -0,0 +1,122 @@
+package zen
+
+import (
+	"context"
+	"regexp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/unkeyed/unkey/go/pkg/fault"
+)
+
+// LongRunningRequestRE 匹配那些本身就是长连接/流式的路由
+// 这些路由不计入 WithMaxInFlight 的并发上限，否则一个慢客户端会占满名额，
+// 把所有短请求都挤出去
+var LongRunningRequestRE = regexp.MustCompile(`^/v[0-9]+/(ratelimit|analytics)/stream`)
+
+var admissionMeter = otel.Meter("github.com/unkeyed/unkey/go/pkg/zen")
+
+// admissionMetrics 是 WithMaxInFlight / WithRateLimit 共用的准入指标
+// 初始化失败时计数器保持零值，不影响中间件本身的行为
+var admissionMetrics = newAdmissionMetricSet()
+
+type admissionMetricSet struct {
+	admitted metric.Int64Counter
+	rejected metric.Int64Counter
+	// queued 跟踪 WithMaxInFlight 当前占用的并发名额数；两个中间件都没有真正的等待队列
+	// （满了就直接拒绝），这里衡量的是"正在处理中"的请求数，而不是排队等待的请求数
+	queued metric.Int64UpDownCounter
+}
+
+func newAdmissionMetricSet() admissionMetricSet {
+	admitted, _ := admissionMeter.Int64Counter("zen_requests_admitted_total")
+	rejected, _ := admissionMeter.Int64Counter("zen_requests_rejected_total")
+	queued, _ := admissionMeter.Int64UpDownCounter("zen_requests_queued")
+	return admissionMetricSet{admitted: admitted, rejected: rejected, queued: queued}
+}
+
+func isLongRunningRoute(s *Session) bool {
+	return LongRunningRequestRE.MatchString(s.Request().URL.Path)
+}
+
+// WithMaxInFlight 拒绝超过并发上限的请求，返回 429 + fault.RATE_LIMITED
+// 命中 LongRunningRequestRE 的路由（例如流式接口）不占用并发名额
+func WithMaxInFlight(n int) Middleware {
+	slots := make(chan struct{}, n)
+
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, s *Session) error {
+			if isLongRunningRoute(s) {
+				return next(ctx, s)
+			}
+
+			select {
+			case slots <- struct{}{}:
+				admissionMetrics.queued.Add(ctx, 1)
+				defer func() {
+					admissionMetrics.queued.Add(ctx, -1)
+					<-slots
+				}()
+			default:
+				admissionMetrics.rejected.Add(ctx, 1)
+				return fault.New("server has reached its maximum number of in-flight requests",
+					fault.WithTag(fault.RATE_LIMITED),
+				)
+			}
+
+			admissionMetrics.admitted.Add(ctx, 1)
+			return next(ctx, s)
+		}
+	}
+}
+
+// RateLimitResult 是 RateLimiter.Take 的结果
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int64
+}
+
+// RateLimiter 是 WithRateLimit 依赖的限流服务接口
+// 形状上对应 apps/agent 里的 ratelimit.Service，但 zen 所在的 go 模块不依赖 apps/agent，
+// 所以这里单独声明一个结构相同的最小接口，由调用方用真正的 ratelimit.Service 实现它
+type RateLimiter interface {
+	Take(ctx context.Context, identifier string) (RateLimitResult, error)
+}
+
+// RateLimitConfig 配置 WithRateLimit
+type RateLimitConfig struct {
+	Limiter RateLimiter
+	// KeyFunc 从请求里提取限流维度，比如路由路径或 API key
+	// 不设置时默认按请求路径做 per-route 限流
+	KeyFunc func(ctx context.Context, s *Session) string
+}
+
+// WithRateLimit 基于令牌桶对请求做准入控制，超出配额返回 429 + fault.RATE_LIMITED
+// 命中 LongRunningRequestRE 的路由不计入限流
+func WithRateLimit(cfg RateLimitConfig) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, s *Session) error {
+			if isLongRunningRoute(s) {
+				return next(ctx, s)
+			}
+
+			key := s.Request().URL.Path
+			if cfg.KeyFunc != nil {
+				key = cfg.KeyFunc(ctx, s)
+			}
+
+			result, err := cfg.Limiter.Take(ctx, key)
+			if err != nil {
+				return fault.Wrap(err, fault.WithTag(fault.INTERNAL_SERVER_ERROR))
+			}
+			if !result.Allowed {
+				admissionMetrics.rejected.Add(ctx, 1)
+				return fault.New("rate limit exceeded", fault.WithTag(fault.RATE_LIMITED))
+			}
+
+			admissionMetrics.admitted.Add(ctx, 1)
+			return next(ctx, s)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package zen
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -18,6 +19,7 @@ import (
 //   - BAD_REQUEST: 400 请求格式错误
 //   - UNAUTHORIZED: 401 未经身份验证
 //   - FORBIDDEN: 403 权限不足
+//   - RATE_LIMITED: 429 请求过于频繁
 //   - PROTECTED_RESOURCE: 412 前置条件不满足
 //   - 其他错误: 500 服务器内部错误
 //
@@ -91,6 +93,10 @@ func WithErrorHandling(logger logging.Logger) Middleware {
 				})
 
 			case fault.UNAUTHORIZED:
+				var challenge *Challenge
+				if errors.As(err, &challenge) {
+					s.ResponseWriter().Header().Set("WWW-Authenticate", challenge.Header())
+				}
 				return s.JSON(http.StatusUnauthorized, api.UnauthorizedError{
 					Title:     "Unauthorized",
 					Type:      "https://unkey.com/docs/errors/unauthorized",
@@ -128,6 +134,16 @@ func WithErrorHandling(logger logging.Logger) Middleware {
 					Instance:  nil,
 				})
 
+			case fault.RATE_LIMITED:
+				return s.JSON(http.StatusTooManyRequests, api.RatelimitError{
+					Title:     "Too Many Requests",
+					Type:      "https://unkey.com/docs/errors/ratelimited",
+					Detail:    fault.UserFacingMessage(err),
+					RequestId: s.requestID,
+					Status:    http.StatusTooManyRequests,
+					Instance:  nil,
+				})
+
 			case fault.DATABASE_ERROR:
 				break // 返回默认500错误
 
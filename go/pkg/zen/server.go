@@ -0,0 +1,7 @@
+package zen
+
+// Server 是 zen 路由树的挂载点，负责持有跨请求共享的状态
+// 这里只列出与鉴权注册相关的部分；监听、路由表等字段在本包的其他地方维护
+type Server struct {
+	authControllers authControllers
+}